@@ -0,0 +1,699 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	ctconf "github.com/hashicorp/consul-template/config"
+	"github.com/hashicorp/consul-template/manager"
+	"github.com/hashicorp/consul-template/signals"
+
+	"github.com/hashicorp/nomad/client/config"
+	"github.com/hashicorp/nomad/client/driver/env"
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+const (
+	// consulTemplateSourceName is the source name when using the TaskHooks.
+	consulTemplateSourceName = "Template"
+)
+
+// testRetryRate is used to speed up tests by lowering the retry rate of the
+// underlying consul-template watcher. It must not be set outside of tests.
+var testRetryRate = 0 * time.Second
+
+// TaskHooks is an interface which provides hooks into the tasks lifecycle.
+// TaskHooks implementations must be safe for concurrent use. Coalescing
+// fires Restart/Signal from the timer goroutine that applyChanges' state
+// machine schedules (one per distinct pending signal, to honor each
+// template's own Splay), which runs concurrently with run's own goroutine
+// calling Kill off the Runner's error channel, so more than one of these
+// methods can be invoked at the same time.
+type TaskHooks interface {
+	// Restart is used to restart the task.
+	Restart(source, reason string)
+
+	// Signal is used to signal the task.
+	Signal(source, reason string, s os.Signal)
+
+	// UnblockStart is used to unblock the starting of the task. This should
+	// be called after all prestart work is completed.
+	UnblockStart(source string)
+
+	// Kill is used to kill the task because of the passed error.
+	Kill(source, reason string)
+}
+
+// ScriptExecutor is an optional interface a TaskHooks implementation may
+// satisfy to support TemplateChangeModeScript. It mirrors the Exec method
+// task drivers expose for script-based health checks, running the command
+// inside the task's own driver context (container, chroot, ...).
+type ScriptExecutor interface {
+	Exec(timeout time.Duration, cmd string, args []string) ([]byte, int, error)
+}
+
+// templateRunnerEnv bundles the pieces of state that a consul-template
+// Runner is built from, so that the same inputs can be used to rebuild the
+// runner on Reload.
+type templateRunnerEnv struct {
+	backends []TemplateBackend
+	taskDir  string
+	taskEnv  *env.TaskEnvironment
+}
+
+// TaskTemplateManager is used to run a set of templates for a given task and
+// drive the task's lifecycle hooks (unblocking, signalling and restarting)
+// off of consul-template's rendering events.
+type TaskTemplateManager struct {
+	hooks TaskHooks
+	env   *templateRunnerEnv
+
+	// runnerLock guards runner and lookup so Reload can safely swap in a
+	// new consul-template Runner while run is reading them.
+	runnerLock sync.Mutex
+	runner     *manager.Runner
+	lookup     map[*ctconf.TemplateConfig]*structs.Template
+
+	// hashLock guards lastHashes, the content hash of the last bytes
+	// written to each destination, used to suppress ChangeMode for
+	// no-op re-renders.
+	hashLock   sync.Mutex
+	lastHashes map[string][32]byte
+
+	// allRendered is set if all templates must render before the task is
+	// unblocked, even templates that have no dynamic dependencies.
+	allRendered bool
+
+	// coalesceLock guards the pending-change state used to debounce bursts
+	// of re-renders within a template's ChangeWait before firing its
+	// ChangeMode.
+	coalesceLock   sync.Mutex
+	coalesceTimer  *time.Timer
+	pendingRestart bool
+	pendingSignals map[os.Signal]*structs.Template
+	pendingScripts map[*structs.Template]struct{}
+
+	// reloadCh notifies run that Reload has swapped in a new runner, so it
+	// should stop waiting on the old one and pick up the new one instead of
+	// running against a stale, already-stopped Runner forever.
+	reloadCh chan struct{}
+
+	// watchCtx/watchCancel bound the lifetime of the backendWatcher
+	// goroutines started for backends (e.g. FileBackend) that notice
+	// changes themselves rather than through the shared Runner's poll loop.
+	watchCtx    context.Context
+	watchCancel context.CancelFunc
+
+	shutdownCh   chan struct{}
+	shutdownLock sync.Mutex
+	shutdown     bool
+}
+
+// NewTaskTemplateManager creates a new TaskTemplateManager and starts
+// rendering templates immediately. backends supplies the set of
+// TemplateBackend implementations templates may pull dynamic values from;
+// ConsulBackend and VaultBackend preserve the existing `{{key}}`/`{{secret}}`
+// funcs, while additional backends such as FileBackend can be registered by
+// the caller.
+func NewTaskTemplateManager(hooks TaskHooks, tmpls []*structs.Template, allRendered bool,
+	config *config.Config, backends []TemplateBackend, taskDir string, taskEnv *env.TaskEnvironment) (*TaskTemplateManager, error) {
+
+	if hooks == nil {
+		return nil, fmt.Errorf("task hook must be given")
+	}
+	if config == nil {
+		return nil, fmt.Errorf("config must be given")
+	}
+	if taskDir == "" {
+		return nil, fmt.Errorf("task directory must be given")
+	}
+	if taskEnv == nil {
+		return nil, fmt.Errorf("task environment must be given")
+	}
+
+	tm := &TaskTemplateManager{
+		hooks: hooks,
+		env: &templateRunnerEnv{
+			backends: backends,
+			taskDir:  taskDir,
+			taskEnv:  taskEnv,
+		},
+		allRendered: allRendered,
+		lastHashes:  make(map[string][32]byte),
+		reloadCh:    make(chan struct{}, 1),
+		shutdownCh:  make(chan struct{}),
+	}
+
+	runner, lookup, err := newTemplateRunner(tmpls, tm.env)
+	if err != nil {
+		return nil, err
+	}
+	tm.runner = runner
+	tm.lookup = lookup
+
+	tm.watchCtx, tm.watchCancel = context.WithCancel(context.Background())
+	tm.watchBackends()
+
+	go tm.run()
+
+	return tm, nil
+}
+
+// newTemplateRunner validates the given templates and builds a
+// consul-template Runner plus a lookup from the runner's template configs
+// back to the owning structs.Template.
+func newTemplateRunner(tmpls []*structs.Template, runnerEnv *templateRunnerEnv) (*manager.Runner, map[*ctconf.TemplateConfig]*structs.Template, error) {
+	if len(tmpls) == 0 {
+		return nil, nil, nil
+	}
+
+	for _, tmpl := range tmpls {
+		switch tmpl.ChangeMode {
+		case structs.TemplateChangeModeSignal:
+			if _, err := signals.Parse(tmpl.ChangeSignal); err != nil {
+				return nil, nil, fmt.Errorf("Failed to parse signal %q: %v", tmpl.ChangeSignal, err)
+			}
+		case structs.TemplateChangeModeScript:
+			if tmpl.ChangeScript == nil || tmpl.ChangeScript.Command == "" {
+				return nil, nil, fmt.Errorf("Failed to validate change script: command cannot be empty")
+			}
+			if tmpl.ChangeScript.Timeout <= 0 {
+				return nil, nil, fmt.Errorf("Failed to parse change script timeout %v: must be positive", tmpl.ChangeScript.Timeout)
+			}
+		}
+
+		if err := validateTemplateFuncs(tmpl, runnerEnv.backends); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	runnerConfig, err := runnerConfigFromNomad(runnerEnv)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lookup := make(map[*ctconf.TemplateConfig]*structs.Template, len(tmpls))
+	ctmpls := make([]*ctconf.TemplateConfig, 0, len(tmpls))
+	for _, tmpl := range tmpls {
+		ctmpl, err := newRunnerTemplate(tmpl, runnerEnv)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		ctmpls = append(ctmpls, ctmpl)
+		lookup[ctmpl] = tmpl
+	}
+
+	runnerConfig.Templates = &ctmpls
+	runner, err := manager.NewRunner(runnerConfig, false, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return runner, lookup, nil
+}
+
+// templateFuncPattern matches a Go template action invoking one of the
+// backend-provided functions, e.g. `{{key "foo"}}` or `{{with secret "db"}}`.
+var templateFuncPattern = regexp.MustCompile(`\b(key|keys|service|services|secret|secrets|file)\b\s*["(\x60]`)
+
+// validateTemplateFuncs rejects a template at construction time if it
+// invokes a backend-provided function (e.g. "key", "secret", "file") that no
+// backend registered on the task actually provides, rather than letting the
+// template fail or hang once the Runner tries to resolve it. Only the
+// template's EmbeddedTmpl is scanned; a template read from SourcePath is not
+// checked.
+func validateTemplateFuncs(tmpl *structs.Template, backends []TemplateBackend) error {
+	available := make(map[string]struct{})
+	for _, b := range backends {
+		for _, fn := range b.Funcs() {
+			available[fn] = struct{}{}
+		}
+	}
+
+	for _, match := range templateFuncPattern.FindAllStringSubmatch(tmpl.EmbeddedTmpl, -1) {
+		fn := match[1]
+		if _, ok := available[fn]; !ok {
+			return fmt.Errorf("template %q uses %q but no registered backend provides it", tmpl.DestPath, fn)
+		}
+	}
+
+	return nil
+}
+
+// newRunnerTemplate converts a single structs.Template into the
+// consul-template configuration used to drive the Runner.
+func newRunnerTemplate(tmpl *structs.Template, runnerEnv *templateRunnerEnv) (*ctconf.TemplateConfig, error) {
+	dest := filepath.Join(runnerEnv.taskDir, runnerEnv.taskEnv.ReplaceEnv(tmpl.DestPath))
+	content := tmpl.EmbeddedTmpl
+
+	ctmpl := ctconf.DefaultTemplateConfig()
+	ctmpl.Destination = &dest
+	ctmpl.Contents = &content
+	if tmpl.SourcePath != "" {
+		source := runnerEnv.taskEnv.ReplaceEnv(tmpl.SourcePath)
+		ctmpl.Source = &source
+	}
+	if tmpl.LeftDelim != "" {
+		ctmpl.LeftDelim = &tmpl.LeftDelim
+	}
+	if tmpl.RightDelim != "" {
+		ctmpl.RightDelim = &tmpl.RightDelim
+	}
+	ctmpl.Finalize()
+
+	return ctmpl, nil
+}
+
+// runnerConfigFromNomad builds the consul-template runner configuration,
+// letting each registered TemplateBackend configure the pieces of the
+// shared Runner it owns. FileBackend needs no configuration here, since
+// consul-template reads files for `{{file}}` itself; validateTemplateFuncs
+// still requires a FileBackend to be registered for templates that use it.
+func runnerConfigFromNomad(runnerEnv *templateRunnerEnv) (*ctconf.Config, error) {
+	conf := ctconf.DefaultConfig()
+
+	for _, backend := range runnerEnv.backends {
+		switch b := backend.(type) {
+		case *ConsulBackend:
+			conf.Consul.Address = &b.Addr
+		case *VaultBackend:
+			conf.Vault.Address = &b.Addr
+			conf.Vault.Token = &b.Token
+		}
+	}
+
+	if testRetryRate != 0 {
+		conf.Consul.Retry.Backoff = &testRetryRate
+		conf.Vault.Retry.Backoff = &testRetryRate
+	}
+
+	conf.Finalize()
+	return conf, nil
+}
+
+// run is the long lived goroutine that listens for render events from the
+// consul-template runner and drives the task hooks in response. It runs one
+// generation of the underlying Runner at a time via runOnce, moving on to
+// the next generation whenever Reload swaps one in, so a Reload never
+// leaves the task without anything consuming its Runner's events.
+func (tm *TaskTemplateManager) run() {
+	for !tm.runOnce() {
+	}
+}
+
+// runOnce drives a single generation of tm.currentRunner() until it is
+// superseded by a Reload or the manager is shut down. It returns true once
+// the manager should stop for good, and false if run should move on to
+// whatever Reload just swapped in.
+func (tm *TaskTemplateManager) runOnce() bool {
+	runner, lookup := tm.currentRunner()
+
+	// No templates, nothing to render; unblock immediately.
+	if runner == nil {
+		tm.hooks.UnblockStart(consulTemplateSourceName)
+
+		select {
+		case <-tm.shutdownCh:
+			return true
+		case <-tm.reloadCh:
+			return false
+		}
+	}
+
+	go runner.Start()
+
+	unblocked := false
+	for {
+		select {
+		case <-tm.shutdownCh:
+			runner.Stop()
+			return true
+		case <-tm.reloadCh:
+			return false
+		case err, ok := <-runner.ErrCh:
+			if !ok {
+				continue
+			}
+			tm.hooks.Kill(consulTemplateSourceName, err.Error())
+		case <-runner.TemplateRenderedCh():
+			events := runner.RenderEvents()
+
+			// Not all templates have rendered yet.
+			if len(events) < len(lookup) {
+				continue
+			}
+
+			changed := tm.handleRenderEvents(lookup, events)
+
+			if !unblocked {
+				unblocked = true
+				tm.hooks.UnblockStart(consulTemplateSourceName)
+
+				if !tm.allRendered {
+					continue
+				}
+			}
+
+			tm.applyChanges(changed)
+		}
+	}
+}
+
+// currentRunner returns the runner and lookup under the runner lock so it is
+// safe to call concurrently with Reload.
+func (tm *TaskTemplateManager) currentRunner() (*manager.Runner, map[*ctconf.TemplateConfig]*structs.Template) {
+	tm.runnerLock.Lock()
+	defer tm.runnerLock.Unlock()
+	return tm.runner, tm.lookup
+}
+
+// handleRenderEvents walks the set of render events and returns the
+// templates whose rendered content actually changed, i.e. whose ChangeMode
+// needs to be applied. consul-template still writes the file on every
+// render (so permissions/atime stay current), but a render that reproduces
+// the same bytes as last time (e.g. a Consul write that doesn't change the
+// value) is not considered a change.
+func (tm *TaskTemplateManager) handleRenderEvents(lookup map[*ctconf.TemplateConfig]*structs.Template,
+	events map[string]*manager.RenderEvent) []*structs.Template {
+
+	tm.hashLock.Lock()
+	defer tm.hashLock.Unlock()
+
+	var changed []*structs.Template
+	for _, event := range events {
+		if !event.DidRender {
+			continue
+		}
+
+		sum := sha256.Sum256(event.Contents)
+
+		for _, ctmpl := range event.TemplateConfigs {
+			tmpl, ok := lookup[ctmpl]
+			if !ok {
+				continue
+			}
+
+			dest := *ctmpl.Destination
+			if prev, ok := tm.lastHashes[dest]; ok && prev == sum {
+				continue
+			}
+			tm.lastHashes[dest] = sum
+
+			changed = append(changed, tmpl)
+		}
+	}
+
+	return changed
+}
+
+// applyChanges queues each changed template's configured ChangeMode onto the
+// coalescing state machine. Rather than firing immediately, a burst of
+// re-renders within a template's ChangeWait is collapsed into a single
+// restart (deduped) and a single signal per distinct signal type, fired
+// after the longest ChangeWait requested by the batch has elapsed.
+func (tm *TaskTemplateManager) applyChanges(changed []*structs.Template) {
+	if len(changed) == 0 {
+		return
+	}
+
+	tm.coalesceLock.Lock()
+	defer tm.coalesceLock.Unlock()
+
+	var wait time.Duration
+	for _, tmpl := range changed {
+		if tmpl.ChangeWait > wait {
+			wait = tmpl.ChangeWait
+		}
+
+		switch tmpl.ChangeMode {
+		case structs.TemplateChangeModeNoop:
+		case structs.TemplateChangeModeSignal:
+			sig, err := signals.Parse(tmpl.ChangeSignal)
+			if err != nil {
+				log.Printf("[ERR] client.template: failed to parse signal %q: %v", tmpl.ChangeSignal, err)
+				continue
+			}
+			if tm.pendingSignals == nil {
+				tm.pendingSignals = make(map[os.Signal]*structs.Template)
+			}
+			tm.pendingSignals[sig] = tmpl
+		case structs.TemplateChangeModeRestart:
+			tm.pendingRestart = true
+		case structs.TemplateChangeModeScript:
+			if tm.pendingScripts == nil {
+				tm.pendingScripts = make(map[*structs.Template]struct{})
+			}
+			tm.pendingScripts[tmpl] = struct{}{}
+		}
+	}
+
+	if tm.coalesceTimer != nil {
+		tm.coalesceTimer.Stop()
+	}
+	tm.coalesceTimer = time.AfterFunc(wait, tm.fireChanges)
+}
+
+// fireChanges is invoked once ChangeWait has elapsed with no further
+// re-renders. It fires the deduped restart, if any, and dispatches each
+// pending signal after its own random Splay delay.
+func (tm *TaskTemplateManager) fireChanges() {
+	tm.coalesceLock.Lock()
+	restart := tm.pendingRestart
+	pendingSignals := tm.pendingSignals
+	pendingScripts := tm.pendingScripts
+	tm.pendingRestart = false
+	tm.pendingSignals = nil
+	tm.pendingScripts = nil
+	tm.coalesceLock.Unlock()
+
+	if restart {
+		tm.hooks.Restart(consulTemplateSourceName, "template re-rendered")
+	}
+
+	for sig, tmpl := range pendingSignals {
+		sig, tmpl := sig, tmpl
+		time.AfterFunc(splayDelay(tmpl.Splay), func() {
+			tm.hooks.Signal(consulTemplateSourceName, "template re-rendered", sig)
+		})
+	}
+
+	for tmpl := range pendingScripts {
+		go tm.runChangeScript(tmpl, 0)
+	}
+}
+
+const (
+	// scriptRetryBackoff is the baseline delay between change script retry
+	// attempts while attempt is below maxScriptRetries. testRetryRate
+	// overrides it in tests, mirroring the backoff used for the underlying
+	// consul-template watcher.
+	scriptRetryBackoff = 7 * time.Second
+
+	// maxScriptRetries is the number of attempts that use scriptRetryBackoff
+	// before a persistently failing change script falls back to the slower
+	// scriptGiveUpInterval cadence below.
+	maxScriptRetries = 5
+
+	// scriptGiveUpInterval is the retry cadence used once a change script
+	// has exhausted maxScriptRetries' fast backoff. consul-template's own
+	// rendering failures retry indefinitely at a fixed fast interval;
+	// change scripts deliberately deviate from that once they've proven
+	// persistently broken, since a script (unlike a render) can have side
+	// effects worth not hammering every few seconds forever. Retries never
+	// stop outright, so a transient outage (e.g. a brief nginx restart)
+	// still recovers on its own without operator intervention.
+	scriptGiveUpInterval = 5 * time.Minute
+)
+
+// runChangeScript executes a template's ChangeScript inside the task's
+// driver context, capturing its output into the task's alloc/logs directory.
+// Both a non-zero exit and an exec error (including a timeout) are treated
+// as a rendering failure and retried with backoff, the same as any other
+// template rendering failure, rather than affecting the task itself.
+func (tm *TaskTemplateManager) runChangeScript(tmpl *structs.Template, attempt int) {
+	script := tmpl.ChangeScript
+
+	executor, ok := tm.hooks.(ScriptExecutor)
+	if !ok {
+		log.Printf("[WARN] client.template: task does not support script execution; skipping change script %q", script.Command)
+		return
+	}
+
+	output, code, err := executor.Exec(script.Timeout, script.Command, script.Args)
+	if werr := tm.writeScriptLog(tmpl, output); werr != nil {
+		log.Printf("[ERR] client.template: failed to write change script output for %q: %v", tmpl.DestPath, werr)
+	}
+
+	if err == nil && code == 0 {
+		return
+	}
+
+	if err != nil {
+		log.Printf("[ERR] client.template: change script %q failed: %v", script.Command, err)
+	} else {
+		log.Printf("[ERR] client.template: change script %q exited with code %d", script.Command, code)
+	}
+
+	backoff := scriptRetryBackoff
+	if attempt >= maxScriptRetries {
+		if attempt == maxScriptRetries {
+			log.Printf("[ERR] client.template: change script %q still failing after %d attempts, backing off to a retry every %s until it succeeds or the manager stops",
+				script.Command, attempt+1, scriptGiveUpInterval)
+		}
+		backoff = scriptGiveUpInterval
+	}
+	if testRetryRate != 0 {
+		backoff = testRetryRate
+	}
+
+	time.AfterFunc(backoff, func() {
+		select {
+		case <-tm.shutdownCh:
+			return
+		default:
+			tm.runChangeScript(tmpl, attempt+1)
+		}
+	})
+}
+
+// writeScriptLog appends a change script's captured output to the task's
+// alloc/logs directory, mirroring where driver-managed stdout/stderr lives.
+func (tm *TaskTemplateManager) writeScriptLog(tmpl *structs.Template, output []byte) error {
+	logDir := filepath.Join(filepath.Dir(tm.env.taskDir), "alloc", "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s.change-script.log", filepath.Base(tmpl.DestPath))
+	f, err := os.OpenFile(filepath.Join(logDir, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(output)
+	return err
+}
+
+// splayDelay returns a random duration in [0, splay), or 0 if no splay is
+// configured.
+func splayDelay(splay time.Duration) time.Duration {
+	if splay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(splay)))
+}
+
+// watchBackends starts a watcher goroutine for every registered backend
+// that implements backendWatcher, so a change a backend notices itself
+// (e.g. FileBackend's fsnotify watch on a sidecar-managed file) triggers an
+// immediate re-render instead of waiting on the shared Runner's own poll
+// interval.
+func (tm *TaskTemplateManager) watchBackends() {
+	for _, backend := range tm.env.backends {
+		watcher, ok := backend.(backendWatcher)
+		if !ok {
+			continue
+		}
+
+		go func(name string, watcher backendWatcher) {
+			if err := watcher.Watch(tm.watchCtx, tm.forceRerender); err != nil {
+				log.Printf("[ERR] client.template: %s backend watch failed: %v", name, err)
+			}
+		}(backend.Name(), watcher)
+	}
+}
+
+// forceRerender rebuilds the current runner generation immediately, rather
+// than waiting on consul-template's own poll interval, in response to a
+// backend noticing its value changed out of band.
+func (tm *TaskTemplateManager) forceRerender() {
+	_, lookup := tm.currentRunner()
+
+	tmpls := make([]*structs.Template, 0, len(lookup))
+	for _, tmpl := range lookup {
+		tmpls = append(tmpls, tmpl)
+	}
+
+	if err := tm.Reload(tmpls); err != nil {
+		log.Printf("[ERR] client.template: failed to re-render after backend change: %v", err)
+	}
+}
+
+// Reload atomically swaps the set of templates being rendered for the task.
+// Any consul-template runner backing the previous set is torn down and a new
+// one is built and started for the given templates, without restarting the
+// task itself.
+//
+// Reload is the sole entrypoint for picking up template edits on the fly; it
+// is deliberately not wired to an OS signal here. A single Nomad client
+// agent process hosts one TaskTemplateManager per running task, so a
+// process-wide signal.Notify(syscall.SIGHUP) in this package would reload
+// every task's templates at once rather than the one task an operator
+// targeted. Callers that want a SIGHUP-style trigger (e.g. the agent's own
+// task update handling, which already knows which allocation/task a signal
+// or job spec change applies to) should call Reload directly for that
+// task's manager.
+func (tm *TaskTemplateManager) Reload(templates []*structs.Template) error {
+	runner, lookup, err := newTemplateRunner(templates, tm.env)
+	if err != nil {
+		return err
+	}
+
+	tm.runnerLock.Lock()
+	old := tm.runner
+	tm.runner = runner
+	tm.lookup = lookup
+	tm.runnerLock.Unlock()
+
+	tm.hashLock.Lock()
+	tm.lastHashes = make(map[string][32]byte)
+	tm.hashLock.Unlock()
+
+	if old != nil {
+		old.Stop()
+	}
+
+	// Wake run's current generation so it picks up the runner just swapped
+	// in above instead of continuing to wait on the one just stopped. The
+	// channel is buffered by one, so a reload that lands while run is still
+	// busy handling a prior one isn't lost.
+	select {
+	case tm.reloadCh <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Stop is used to stop the consul-template runner.
+func (tm *TaskTemplateManager) Stop() {
+	tm.shutdownLock.Lock()
+	defer tm.shutdownLock.Unlock()
+
+	if tm.shutdown {
+		return
+	}
+
+	close(tm.shutdownCh)
+	tm.shutdown = true
+
+	if tm.watchCancel != nil {
+		tm.watchCancel()
+	}
+
+	tm.coalesceLock.Lock()
+	if tm.coalesceTimer != nil {
+		tm.coalesceTimer.Stop()
+	}
+	tm.coalesceLock.Unlock()
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/hashicorp/consul-template/dependency"
+)
+
+// TemplateBackend is implemented by anything a task's templates can pull
+// dynamic values from. NewTaskTemplateManager takes a slice of these instead
+// of hard-coding Consul and Vault, so operators can register additional
+// sources (or omit ones they don't need).
+type TemplateBackend interface {
+	// Name identifies the backend in logs and error messages.
+	Name() string
+
+	// Dependencies lists the consul-template dependency types this backend
+	// resolves. The built-in backends return nil; templates are validated
+	// against Funcs, not Dependencies.
+	Dependencies() []dependency.Dependency
+
+	// Fetch retrieves the backend's current value. TaskTemplateManager does
+	// not call it: the template funcs of all built-in backends (ConsulBackend,
+	// VaultBackend, FileBackend) are resolved by the shared Runner, and
+	// ConsulBackend and VaultBackend return an error from Fetch.
+	Fetch(ctx context.Context) (interface{}, error)
+
+	// Funcs lists the template function names (e.g. "key", "secret") this
+	// backend makes available, so newTemplateRunner can reject an embedded
+	// template at construction time if it uses a function no registered
+	// backend provides, rather than failing or hanging at render time.
+	Funcs() []string
+}
+
+// backendWatcher is implemented by backends that can notice a change to
+// their underlying value themselves, outside of the shared consul-template
+// Runner's own poll loop. NewTaskTemplateManager watches every registered
+// backend that implements this and forces an immediate re-render (rather
+// than waiting on the Runner's next poll) whenever onChange fires.
+type backendWatcher interface {
+	Watch(ctx context.Context, onChange func()) error
+}
+
+// ConsulBackend wires the existing `{{key}}`/`{{keys}}`/`{{service}}`
+// template funcs, backed by the agent's configured Consul client. It is
+// resolved natively by the shared consul-template Runner; registering it
+// only configures the Runner's Consul client and declares the funcs it
+// unlocks.
+type ConsulBackend struct {
+	Addr string
+}
+
+func NewConsulBackend(addr string) *ConsulBackend { return &ConsulBackend{Addr: addr} }
+
+func (b *ConsulBackend) Name() string                          { return "consul" }
+func (b *ConsulBackend) Dependencies() []dependency.Dependency { return nil }
+func (b *ConsulBackend) Funcs() []string                       { return []string{"key", "keys", "service", "services"} }
+func (b *ConsulBackend) Fetch(ctx context.Context) (interface{}, error) {
+	return nil, fmt.Errorf("consul backend is resolved by the shared consul-template runner")
+}
+
+// VaultBackend wires the existing `{{secret}}`/`{{secrets}}` template funcs,
+// backed by the agent's configured Vault client and the task's Vault token.
+// Like ConsulBackend, it is resolved natively by the shared Runner.
+type VaultBackend struct {
+	Addr  string
+	Token string
+}
+
+func NewVaultBackend(addr, token string) *VaultBackend {
+	return &VaultBackend{Addr: addr, Token: token}
+}
+
+func (b *VaultBackend) Name() string                          { return "vault" }
+func (b *VaultBackend) Dependencies() []dependency.Dependency { return nil }
+func (b *VaultBackend) Funcs() []string                       { return []string{"secret", "secrets"} }
+func (b *VaultBackend) Fetch(ctx context.Context) (interface{}, error) {
+	return nil, fmt.Errorf("vault backend is resolved by the shared consul-template runner")
+}
+
+// FileBackend lets a task template off a local, sidecar-managed file via
+// `{{file "path"}}`. consul-template resolves that function's reads
+// natively, but FileBackend additionally watches the file with fsnotify and
+// drives an immediate re-render the moment it changes, instead of relying
+// solely on consul-template's own file poll interval.
+type FileBackend struct {
+	Path string
+}
+
+func NewFileBackend(path string) *FileBackend { return &FileBackend{Path: path} }
+
+func (b *FileBackend) Name() string                          { return "file" }
+func (b *FileBackend) Dependencies() []dependency.Dependency { return nil }
+func (b *FileBackend) Funcs() []string                       { return []string{"file"} }
+
+// Fetch returns the file's current contents, for callers that want the
+// current value without waiting on a render cycle.
+func (b *FileBackend) Fetch(ctx context.Context) (interface{}, error) {
+	return ioutil.ReadFile(b.Path)
+}
+
+// Watch blocks until ctx is done or an error occurs, invoking onChange each
+// time the backend's file is created, written or removed.
+func (b *FileBackend) Watch(ctx context.Context, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(b.Path)); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == filepath.Clean(b.Path) {
+				onChange()
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,37 +21,120 @@ import (
 	"github.com/hashicorp/nomad/testutil"
 )
 
-// MockTaskHooks is a mock of the TaskHooks interface useful for testing
+// MockTaskHooks is a mock of the TaskHooks interface useful for testing.
+// Coalescing can call Restart/Signal/Kill from multiple goroutines at once
+// (see the concurrency note on TaskHooks), so every field is guarded by mu;
+// tests must read state through the Get* accessors rather than the fields
+// directly.
 type MockTaskHooks struct {
-	Restarts   int
-	Signals    []os.Signal
-	Unblocked  bool
-	KillReason string
+	mu sync.Mutex
+
+	restarts   int
+	signals    []os.Signal
+	unblocked  bool
+	killReason string
+
+	// ExecFn backs Exec so tests can exercise TemplateChangeModeScript
+	// without a real task driver. It is set once during test setup, before
+	// the template change that triggers a change script, and is not guarded
+	// by mu.
+	ExecFn func(timeout time.Duration, cmd string, args []string) ([]byte, int, error)
 }
 
-func NewMockTaskHooks() *MockTaskHooks                             { return &MockTaskHooks{} }
-func (m *MockTaskHooks) Restart(source, reason string)             { m.Restarts++ }
-func (m *MockTaskHooks) Signal(source, reason string, s os.Signal) { m.Signals = append(m.Signals, s) }
-func (m *MockTaskHooks) UnblockStart(source string)                { m.Unblocked = true }
-func (m *MockTaskHooks) Kill(source, reason string)                { m.KillReason = reason }
+func NewMockTaskHooks() *MockTaskHooks { return &MockTaskHooks{} }
+
+func (m *MockTaskHooks) Restart(source, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.restarts++
+}
+
+func (m *MockTaskHooks) Signal(source, reason string, s os.Signal) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signals = append(m.signals, s)
+}
+
+func (m *MockTaskHooks) UnblockStart(source string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unblocked = true
+}
+
+func (m *MockTaskHooks) Kill(source, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.killReason = reason
+}
+
+func (m *MockTaskHooks) Exec(timeout time.Duration, cmd string, args []string) ([]byte, int, error) {
+	if m.ExecFn == nil {
+		return nil, 0, nil
+	}
+	return m.ExecFn(timeout, cmd, args)
+}
+
+// GetRestarts returns the number of times Restart has been called.
+func (m *MockTaskHooks) GetRestarts() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.restarts
+}
+
+// GetSignals returns a copy of the signals received so far.
+func (m *MockTaskHooks) GetSignals() []os.Signal {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]os.Signal(nil), m.signals...)
+}
+
+// GetUnblocked reports whether UnblockStart has been called.
+func (m *MockTaskHooks) GetUnblocked() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.unblocked
+}
+
+// GetKillReason returns the reason passed to the most recent Kill call.
+func (m *MockTaskHooks) GetKillReason() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.killReason
+}
+
+// String lets test failure messages safely format a MockTaskHooks with
+// %v/%+v without racing its fields directly.
+func (m *MockTaskHooks) String() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return fmt.Sprintf("&MockTaskHooks{Restarts:%d Signals:%v Unblocked:%v KillReason:%q}",
+		m.restarts, m.signals, m.unblocked, m.killReason)
+}
 
 // testHarness is used to test the TaskTemplateManager by spinning up
 // Consul/Vault as needed
 type testHarness struct {
-	manager    *TaskTemplateManager
-	mockHooks  *MockTaskHooks
-	templates  []*structs.Template
-	taskEnv    *env.TaskEnvironment
-	node       *structs.Node
-	config     *config.Config
-	vaultToken string
-	taskDir    string
-	vault      *testutil.TestVault
-	consul     *ctestutil.TestServer
+	manager   *TaskTemplateManager
+	mockHooks *MockTaskHooks
+	templates []*structs.Template
+	taskEnv   *env.TaskEnvironment
+	node      *structs.Node
+	config    *config.Config
+	backends  []TemplateBackend
+
+	// allocDir is the per-test alloc directory; taskDir is the task's
+	// "local" subdirectory within it, mirroring the real Nomad alloc
+	// layout so that writeScriptLog's alloc/logs sibling lands inside
+	// allocDir and is cleaned up by stop() along with everything else.
+	allocDir string
+	taskDir  string
+
+	vault  *testutil.TestVault
+	consul *ctestutil.TestServer
 }
 
 // newTestHarness returns a harness starting a dev consul and vault server,
-// building the appropriate config and creating a TaskTemplateManager
+// building the appropriate backends and creating a TaskTemplateManager
 func newTestHarness(t *testing.T, templates []*structs.Template, allRendered, consul, vault bool) *testHarness {
 	harness := &testHarness{
 		mockHooks: NewMockTaskHooks(),
@@ -60,28 +146,31 @@ func newTestHarness(t *testing.T, templates []*structs.Template, allRendered, co
 	// Build the task environment
 	harness.taskEnv = env.NewTaskEnvironment(harness.node)
 
-	// Make a tempdir
-	d, err := ioutil.TempDir("", "")
+	// Make an alloc dir with a "local" task dir inside it, mirroring the
+	// real Nomad alloc layout so writeScriptLog's alloc/logs sibling lands
+	// inside allocDir and is cleaned up by stop().
+	allocDir, err := ioutil.TempDir("", "")
 	if err != nil {
 		t.Fatalf("Failed to make tmpdir: %v", err)
 	}
-	harness.taskDir = d
+	harness.allocDir = allocDir
+
+	taskDir := filepath.Join(allocDir, "local")
+	if err := os.Mkdir(taskDir, 0755); err != nil {
+		t.Fatalf("Failed to make task dir: %v", err)
+	}
+	harness.taskDir = taskDir
 
 	if consul {
-		harness.consul = ctestutil.NewTestServer(t)
-		harness.config.ConsulConfig = &sconfig.ConsulConfig{
-			Addr: harness.consul.HTTPAddr,
-		}
+		harness.registerConsul(t)
 	}
 
 	if vault {
-		harness.vault = testutil.NewTestVault(t).Start()
-		harness.config.VaultConfig = harness.vault.Config
-		harness.vaultToken = harness.vault.RootToken
+		harness.registerVault(t)
 	}
 
 	manager, err := NewTaskTemplateManager(harness.mockHooks, templates, allRendered,
-		harness.config, harness.vaultToken, harness.taskDir, harness.taskEnv)
+		harness.config, harness.backends, harness.taskDir, harness.taskEnv)
 	if err != nil {
 		t.Fatalf("failed to build task template manager: %v", err)
 	}
@@ -90,6 +179,29 @@ func newTestHarness(t *testing.T, templates []*structs.Template, allRendered, co
 	return harness
 }
 
+// registerConsul starts a dev Consul server and registers a ConsulBackend
+// for it, preserving the `{{key}}` template funcs existing templates rely
+// on.
+func (h *testHarness) registerConsul(t *testing.T) {
+	h.consul = ctestutil.NewTestServer(t)
+	h.config.ConsulConfig = &sconfig.ConsulConfig{Addr: h.consul.HTTPAddr}
+	h.backends = append(h.backends, NewConsulBackend(h.consul.HTTPAddr))
+}
+
+// registerVault starts a dev Vault server and registers a VaultBackend for
+// it, preserving the `{{secret}}` template funcs existing templates rely on.
+func (h *testHarness) registerVault(t *testing.T) {
+	h.vault = testutil.NewTestVault(t).Start()
+	h.config.VaultConfig = h.vault.Config
+	h.backends = append(h.backends, NewVaultBackend(h.vault.Config.Addr, h.vault.RootToken))
+}
+
+// registerFile registers a FileBackend rooted at path so templates can use
+// `{{file "path"}}`.
+func (h *testHarness) registerFile(path string) {
+	h.backends = append(h.backends, NewFileBackend(path))
+}
+
 // stop is used to stop any running Vault or Consul server plus the task manager
 func (h *testHarness) stop() {
 	if h.vault != nil {
@@ -101,7 +213,9 @@ func (h *testHarness) stop() {
 	if h.manager != nil {
 		h.manager.Stop()
 	}
-	if h.taskDir != "" {
+	if h.allocDir != "" {
+		os.RemoveAll(h.allocDir)
+	} else if h.taskDir != "" {
 		os.RemoveAll(h.taskDir)
 	}
 }
@@ -111,40 +225,41 @@ func TestTaskTemplateManager_Invalid(t *testing.T) {
 	var tmpls []*structs.Template
 	config := &config.Config{}
 	taskDir := "foo"
-	vaultToken := ""
+	var backends []TemplateBackend
 	taskEnv := env.NewTaskEnvironment(mock.Node())
 
-	_, err := NewTaskTemplateManager(nil, nil, false, nil, "", "", nil)
+	_, err := NewTaskTemplateManager(nil, nil, false, nil, nil, "", nil)
 	if err == nil {
 		t.Fatalf("Expected error")
 	}
 
-	_, err = NewTaskTemplateManager(nil, tmpls, false, config, vaultToken, taskDir, taskEnv)
+	_, err = NewTaskTemplateManager(nil, tmpls, false, config, backends, taskDir, taskEnv)
 	if err == nil || !strings.Contains(err.Error(), "task hook") {
 		t.Fatalf("Expected invalid task hook error: %v", err)
 	}
 
-	_, err = NewTaskTemplateManager(hooks, tmpls, false, nil, vaultToken, taskDir, taskEnv)
+	_, err = NewTaskTemplateManager(hooks, tmpls, false, nil, backends, taskDir, taskEnv)
 	if err == nil || !strings.Contains(err.Error(), "config") {
 		t.Fatalf("Expected invalid config error: %v", err)
 	}
 
-	_, err = NewTaskTemplateManager(hooks, tmpls, false, config, vaultToken, "", taskEnv)
+	_, err = NewTaskTemplateManager(hooks, tmpls, false, config, backends, "", taskEnv)
 	if err == nil || !strings.Contains(err.Error(), "task directory") {
 		t.Fatalf("Expected invalid task dir error: %v", err)
 	}
 
-	_, err = NewTaskTemplateManager(hooks, tmpls, false, config, vaultToken, taskDir, nil)
+	_, err = NewTaskTemplateManager(hooks, tmpls, false, config, backends, taskDir, nil)
 	if err == nil || !strings.Contains(err.Error(), "task environment") {
 		t.Fatalf("Expected invalid task environment error: %v", err)
 	}
 
-	tm, err := NewTaskTemplateManager(hooks, tmpls, false, config, vaultToken, taskDir, taskEnv)
+	tm, err := NewTaskTemplateManager(hooks, tmpls, false, config, backends, taskDir, taskEnv)
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	} else if tm == nil {
 		t.Fatalf("Bad %v", tm)
 	}
+	tm.Stop()
 
 	// Build a template with a bad signal
 	tmpl := &structs.Template{
@@ -155,10 +270,30 @@ func TestTaskTemplateManager_Invalid(t *testing.T) {
 	}
 
 	tmpls = append(tmpls, tmpl)
-	tm, err = NewTaskTemplateManager(hooks, tmpls, false, config, vaultToken, taskDir, taskEnv)
+	tm, err = NewTaskTemplateManager(hooks, tmpls, false, config, backends, taskDir, taskEnv)
 	if err == nil || !strings.Contains(err.Error(), "Failed to parse signal") {
 		t.Fatalf("Expected signal parsing error: %v", err)
 	}
+
+	// Build a template that uses a backend function with no matching
+	// backend registered
+	tmpls = []*structs.Template{{
+		DestPath:     "foo",
+		EmbeddedTmpl: `{{key "foo"}}`,
+		ChangeMode:   structs.TemplateChangeModeNoop,
+	}}
+	tm, err = NewTaskTemplateManager(hooks, tmpls, false, config, backends, taskDir, taskEnv)
+	if err == nil || !strings.Contains(err.Error(), "no registered backend provides it") {
+		t.Fatalf("Expected missing backend error: %v", err)
+	}
+
+	// Registering a backend that provides the function should let the
+	// same template construct successfully
+	tm, err = NewTaskTemplateManager(hooks, tmpls, false, config, []TemplateBackend{NewConsulBackend("")}, taskDir, taskEnv)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tm.Stop()
 }
 
 func TestTaskTemplateManager_Unblock_Static(t *testing.T) {
@@ -178,7 +313,7 @@ func TestTaskTemplateManager_Unblock_Static(t *testing.T) {
 	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
 
 	// Ensure we have been unblocked
-	if !harness.mockHooks.Unblocked {
+	if !harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should have been called")
 	}
 
@@ -216,7 +351,7 @@ func TestTaskTemplateManager_Unblock_Consul(t *testing.T) {
 	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
 
 	// Ensure we have not been unblocked
-	if harness.mockHooks.Unblocked {
+	if harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should not have been called")
 	}
 
@@ -227,7 +362,7 @@ func TestTaskTemplateManager_Unblock_Consul(t *testing.T) {
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
 	// Ensure we have been unblocked
-	if !harness.mockHooks.Unblocked {
+	if !harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should have been called")
 	}
 
@@ -266,7 +401,7 @@ func TestTaskTemplateManager_Unblock_Vault(t *testing.T) {
 	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
 
 	// Ensure we have not been unblocked
-	if harness.mockHooks.Unblocked {
+	if harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should not have been called")
 	}
 
@@ -278,7 +413,7 @@ func TestTaskTemplateManager_Unblock_Vault(t *testing.T) {
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
 	// Ensure we have been unblocked
-	if !harness.mockHooks.Unblocked {
+	if !harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should have been called")
 	}
 
@@ -294,6 +429,131 @@ func TestTaskTemplateManager_Unblock_Vault(t *testing.T) {
 	}
 }
 
+func TestTaskTemplateManager_Unblock_File(t *testing.T) {
+	// Make a sidecar-managed file outside of the task directory that a
+	// template will render based on
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to make tmpdir: %v", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	srcFile := filepath.Join(srcDir, "sidecar.txt")
+	content := "barbaz"
+	if err := ioutil.WriteFile(srcFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write sidecar file: %v", err)
+	}
+
+	embedded := fmt.Sprintf(`{{file "%s"}}`, srcFile)
+	file := "my.tmpl"
+	template := &structs.Template{
+		EmbeddedTmpl: embedded,
+		DestPath:     file,
+		ChangeMode:   structs.TemplateChangeModeNoop,
+	}
+
+	harness := &testHarness{
+		mockHooks: NewMockTaskHooks(),
+		templates: []*structs.Template{template},
+		node:      mock.Node(),
+		config:    &config.Config{},
+	}
+	harness.taskEnv = env.NewTaskEnvironment(harness.node)
+	d, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to make tmpdir: %v", err)
+	}
+	harness.taskDir = d
+	harness.registerFile(srcFile)
+
+	manager, err := NewTaskTemplateManager(harness.mockHooks, harness.templates, false,
+		harness.config, harness.backends, harness.taskDir, harness.taskEnv)
+	if err != nil {
+		t.Fatalf("failed to build task template manager: %v", err)
+	}
+	harness.manager = manager
+	defer harness.stop()
+
+	// Wait a little while
+	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
+
+	if !harness.mockHooks.GetUnblocked() {
+		t.Fatalf("Task unblock should have been called")
+	}
+
+	path := filepath.Join(harness.taskDir, file)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read rendered template from %q: %v", path, err)
+	}
+
+	if s := string(raw); s != content {
+		t.Fatalf("Unexpected template data; got %q, want %q", s, content)
+	}
+
+	// Update the sidecar file and make sure the template re-renders
+	content2 := "updated"
+	if err := ioutil.WriteFile(srcFile, []byte(content2), 0644); err != nil {
+		t.Fatalf("Failed to update sidecar file: %v", err)
+	}
+
+	time.Sleep(time.Duration(testutil.TestMultiplier()*200) * time.Millisecond)
+
+	raw, err = ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read rendered template from %q: %v", path, err)
+	}
+
+	if s := string(raw); s != content2 {
+		t.Fatalf("Unexpected template data; got %q, want %q", s, content2)
+	}
+}
+
+func TestFileBackend_Watch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to make tmpdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sidecar.txt")
+	if err := ioutil.WriteFile(path, []byte("first"), 0644); err != nil {
+		t.Fatalf("Failed to write sidecar file: %v", err)
+	}
+
+	backend := NewFileBackend(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var notified int32
+	done := make(chan error, 1)
+	go func() {
+		done <- backend.Watch(ctx, func() { atomic.AddInt32(&notified, 1) })
+	}()
+
+	// Give the watcher a moment to register before mutating the file.
+	time.Sleep(time.Duration(testutil.TestMultiplier()*50) * time.Millisecond)
+
+	if err := ioutil.WriteFile(path, []byte("second"), 0644); err != nil {
+		t.Fatalf("Failed to update sidecar file: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Duration(testutil.TestMultiplier()*500) * time.Millisecond)
+	for atomic.LoadInt32(&notified) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if atomic.LoadInt32(&notified) == 0 {
+		t.Fatalf("Expected onChange to be called after the sidecar file was written")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Watch returned an error: %v", err)
+	}
+}
+
 func TestTaskTemplateManager_Unblock_Multi_Template(t *testing.T) {
 	// Make a template that will render immediately
 	staticContent := "hello, world!"
@@ -325,7 +585,7 @@ func TestTaskTemplateManager_Unblock_Multi_Template(t *testing.T) {
 	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
 
 	// Ensure we have not been unblocked
-	if harness.mockHooks.Unblocked {
+	if harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should not have been called")
 	}
 
@@ -347,7 +607,7 @@ func TestTaskTemplateManager_Unblock_Multi_Template(t *testing.T) {
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
 	// Ensure we have been unblocked
-	if !harness.mockHooks.Unblocked {
+	if !harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should have been called")
 	}
 
@@ -386,7 +646,7 @@ func TestTaskTemplateManager_Rerender_Noop(t *testing.T) {
 	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
 
 	// Ensure we have not been unblocked
-	if harness.mockHooks.Unblocked {
+	if harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should not have been called")
 	}
 
@@ -397,7 +657,7 @@ func TestTaskTemplateManager_Rerender_Noop(t *testing.T) {
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
 	// Ensure we have been unblocked
-	if !harness.mockHooks.Unblocked {
+	if !harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should have been called")
 	}
 
@@ -419,7 +679,7 @@ func TestTaskTemplateManager_Rerender_Noop(t *testing.T) {
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
 	// Ensure we haven't been signaled/restarted
-	if harness.mockHooks.Restarts != 0 || len(harness.mockHooks.Signals) != 0 {
+	if harness.mockHooks.GetRestarts() != 0 || len(harness.mockHooks.GetSignals()) != 0 {
 		t.Fatalf("Noop ignored: %+v", harness.mockHooks)
 	}
 
@@ -472,7 +732,7 @@ func TestTaskTemplateManager_Rerender_Signal(t *testing.T) {
 	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
 
 	// Ensure we have not been unblocked
-	if harness.mockHooks.Unblocked {
+	if harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should not have been called")
 	}
 
@@ -484,7 +744,7 @@ func TestTaskTemplateManager_Rerender_Signal(t *testing.T) {
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
 	// Ensure we have been unblocked
-	if !harness.mockHooks.Unblocked {
+	if !harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should have been called")
 	}
 
@@ -496,11 +756,11 @@ func TestTaskTemplateManager_Rerender_Signal(t *testing.T) {
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
 	// Ensure we have been signaled and notrestarted
-	if harness.mockHooks.Restarts != 0 {
+	if harness.mockHooks.GetRestarts() != 0 {
 		t.Fatalf("Should not have been restarted: %+v", harness.mockHooks)
 	}
 
-	if len(harness.mockHooks.Signals) != 2 {
+	if len(harness.mockHooks.GetSignals()) != 2 {
 		t.Fatalf("Should have received two signals: %+v", harness.mockHooks)
 	}
 
@@ -549,7 +809,7 @@ func TestTaskTemplateManager_Rerender_Restart(t *testing.T) {
 	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
 
 	// Ensure we have not been unblocked
-	if harness.mockHooks.Unblocked {
+	if harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should not have been called")
 	}
 
@@ -560,7 +820,7 @@ func TestTaskTemplateManager_Rerender_Restart(t *testing.T) {
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
 	// Ensure we have been unblocked
-	if !harness.mockHooks.Unblocked {
+	if !harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should have been called")
 	}
 
@@ -570,7 +830,7 @@ func TestTaskTemplateManager_Rerender_Restart(t *testing.T) {
 	// Wait a little while
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
-	if harness.mockHooks.Restarts != 1 {
+	if harness.mockHooks.GetRestarts() != 1 {
 		t.Fatalf("Should have received a restart: %+v", harness.mockHooks)
 	}
 
@@ -603,7 +863,7 @@ func TestTaskTemplateManager_Interpolate_Destination(t *testing.T) {
 	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
 
 	// Ensure we have been unblocked
-	if !harness.mockHooks.Unblocked {
+	if !harness.mockHooks.GetUnblocked() {
 		t.Fatalf("Task unblock should have been called")
 	}
 
@@ -648,7 +908,7 @@ func TestTaskTemplateManager_AllRendered_Signal(t *testing.T) {
 	// Wait a little while
 	time.Sleep(time.Duration(200*testutil.TestMultiplier()) * time.Millisecond)
 
-	if len(harness.mockHooks.Signals) != 1 {
+	if len(harness.mockHooks.GetSignals()) != 1 {
 		t.Fatalf("Should have received two signals: %+v", harness.mockHooks)
 	}
 
@@ -663,3 +923,271 @@ func TestTaskTemplateManager_AllRendered_Signal(t *testing.T) {
 		t.Fatalf("Unexpected template data; got %q, want %q", s, content1_1)
 	}
 }
+
+func TestTaskTemplateManager_Reload_AddsAndRemovesTemplates(t *testing.T) {
+	// Start with a single static template
+	staticContent := "hello, world!"
+	staticFile := "my.tmpl"
+	template := &structs.Template{
+		EmbeddedTmpl: staticContent,
+		DestPath:     staticFile,
+		ChangeMode:   structs.TemplateChangeModeNoop,
+	}
+
+	// Drop the retry rate
+	testRetryRate = 10 * time.Millisecond
+
+	harness := newTestHarness(t, []*structs.Template{template}, false, true, false)
+	defer harness.stop()
+
+	// Wait for the initial template to render and unblock the task
+	time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
+	if !harness.mockHooks.GetUnblocked() {
+		t.Fatalf("Task unblock should have been called")
+	}
+
+	// Reload with a new template set containing a signal-triggering template
+	// driven by a Consul key, so we can force a second re-render after the
+	// reload and confirm the manager is still listening for it.
+	key := "foo"
+	content := "goodbye, world!"
+	file := "reloaded.tmpl"
+	embedded := fmt.Sprintf(`{{key "%s"}}`, key)
+	reloaded := &structs.Template{
+		EmbeddedTmpl: embedded,
+		DestPath:     file,
+		ChangeMode:   structs.TemplateChangeModeSignal,
+		ChangeSignal: "SIGHUP",
+	}
+
+	harness.consul.SetKV(key, []byte(content))
+
+	if err := harness.manager.Reload([]*structs.Template{reloaded}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	// Wait for the reloaded template to render
+	time.Sleep(time.Duration(testutil.TestMultiplier()*200) * time.Millisecond)
+
+	// The old template should have been dropped from the manager's set,
+	// leaving only the reloaded one
+	_, lookup := harness.manager.currentRunner()
+	if len(lookup) != 1 {
+		t.Fatalf("Expected only the reloaded template after Reload, got %d", len(lookup))
+	}
+	for _, tmpl := range lookup {
+		if tmpl != reloaded {
+			t.Fatalf("Expected the reloaded template, got %+v", tmpl)
+		}
+	}
+
+	// The new template should be rendered in its place
+	path := filepath.Join(harness.taskDir, file)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read rendered template from %q: %v", path, err)
+	}
+
+	if s := string(raw); s != content {
+		t.Fatalf("Unexpected template data; got %q, want %q", s, content)
+	}
+
+	// The reload itself should not have fired the new template's signal
+	if len(harness.mockHooks.GetSignals()) != 0 {
+		t.Fatalf("Reload should not signal on first render: %+v", harness.mockHooks)
+	}
+
+	// A re-render of the reloaded template set must still reach run's
+	// select loop and fire the new template's ChangeMode; this is the
+	// generation Reload just swapped in, not the one run started with.
+	harness.consul.SetKV(key, []byte("goodbye, world, again!"))
+
+	time.Sleep(time.Duration(testutil.TestMultiplier()*200) * time.Millisecond)
+
+	if len(harness.mockHooks.GetSignals()) != 1 {
+		t.Fatalf("Should have been signaled after reload: %+v", harness.mockHooks)
+	}
+}
+
+func TestTaskTemplateManager_Rerender_ChangeWait_Coalesces(t *testing.T) {
+	// Make a restart template with a ChangeWait so a burst of Consul writes
+	// only restarts once, after quiescence.
+	key := "foo"
+	content := "bar"
+	embedded := fmt.Sprintf(`{{key "%s"}}`, key)
+	file := "my.tmpl"
+	template := &structs.Template{
+		EmbeddedTmpl: embedded,
+		DestPath:     file,
+		ChangeMode:   structs.TemplateChangeModeRestart,
+		ChangeWait:   200 * time.Millisecond,
+	}
+
+	// Drop the retry rate
+	testRetryRate = 10 * time.Millisecond
+
+	harness := newTestHarness(t, []*structs.Template{template}, false, true, false)
+	defer harness.stop()
+
+	// Wait for the initial render to unblock the task
+	harness.consul.SetKV(key, []byte(content))
+	time.Sleep(time.Duration(testutil.TestMultiplier()*200) * time.Millisecond)
+	if !harness.mockHooks.GetUnblocked() {
+		t.Fatalf("Task unblock should have been called")
+	}
+
+	// Fire a burst of rapid successive updates, well inside ChangeWait
+	for i := 0; i < 5; i++ {
+		harness.consul.SetKV(key, []byte(fmt.Sprintf("value-%d", i)))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// No restart should have fired yet; the burst is still coalescing
+	if harness.mockHooks.GetRestarts() != 0 {
+		t.Fatalf("Restart fired before ChangeWait elapsed: %+v", harness.mockHooks)
+	}
+
+	// Wait out ChangeWait plus a margin
+	time.Sleep(time.Duration(testutil.TestMultiplier()*400) * time.Millisecond)
+
+	if harness.mockHooks.GetRestarts() != 1 {
+		t.Fatalf("Expected exactly one coalesced restart: %+v", harness.mockHooks)
+	}
+}
+
+func TestTaskTemplateManager_Rerender_Script(t *testing.T) {
+	key := "foo"
+	content1 := "bar"
+	content2 := "baz"
+	embedded := fmt.Sprintf(`{{key "%s"}}`, key)
+	file := "my.tmpl"
+
+	cases := []struct {
+		name        string
+		execFn      func(timeout time.Duration, cmd string, args []string) ([]byte, int, error)
+		wantRetries bool
+	}{
+		{
+			name: "success",
+			execFn: func(timeout time.Duration, cmd string, args []string) ([]byte, int, error) {
+				return []byte("reloaded\n"), 0, nil
+			},
+		},
+		{
+			name: "non-zero exit",
+			execFn: func(timeout time.Duration, cmd string, args []string) ([]byte, int, error) {
+				return []byte("boom\n"), 1, nil
+			},
+			wantRetries: true,
+		},
+		{
+			name: "timeout",
+			execFn: func(timeout time.Duration, cmd string, args []string) ([]byte, int, error) {
+				return nil, 0, fmt.Errorf("signal: killed")
+			},
+			wantRetries: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			template := &structs.Template{
+				EmbeddedTmpl: embedded,
+				DestPath:     file,
+				ChangeMode:   structs.TemplateChangeModeScript,
+				ChangeScript: &structs.ChangeScript{
+					Command: "/usr/bin/reload",
+					Args:    []string{"-config", "/etc/app.conf"},
+					Timeout: time.Second,
+				},
+			}
+
+			// Drop both the render retry rate and the change script retry
+			// backoff so retries, if any, happen promptly.
+			testRetryRate = 10 * time.Millisecond
+
+			harness := newTestHarness(t, []*structs.Template{template}, false, true, false)
+			defer harness.stop()
+
+			var execs int32
+			harness.mockHooks.ExecFn = func(timeout time.Duration, cmd string, args []string) ([]byte, int, error) {
+				atomic.AddInt32(&execs, 1)
+				return c.execFn(timeout, cmd, args)
+			}
+
+			harness.consul.SetKV(key, []byte(content1))
+			time.Sleep(time.Duration(testutil.TestMultiplier()*200) * time.Millisecond)
+			if !harness.mockHooks.GetUnblocked() {
+				t.Fatalf("Task unblock should have been called")
+			}
+
+			harness.consul.SetKV(key, []byte(content2))
+			time.Sleep(time.Duration(testutil.TestMultiplier()*200) * time.Millisecond)
+
+			// A failing change script must never affect the task itself;
+			// only the standard render retry path should engage.
+			if harness.mockHooks.GetKillReason() != "" {
+				t.Fatalf("Change script failure must not kill the task: %s", harness.mockHooks.GetKillReason())
+			}
+			if harness.mockHooks.GetRestarts() != 0 || len(harness.mockHooks.GetSignals()) != 0 {
+				t.Fatalf("Change script failure must not restart or signal the task: %+v", harness.mockHooks)
+			}
+
+			got := atomic.LoadInt32(&execs)
+			if c.wantRetries && got < 2 {
+				t.Fatalf("Expected the change script to be retried, only ran %d time(s)", got)
+			}
+			if !c.wantRetries && got != 1 {
+				t.Fatalf("Expected the change script to run exactly once, ran %d time(s)", got)
+			}
+		})
+	}
+}
+
+func TestTaskTemplateManager_Rerender_NoopOnSameContent(t *testing.T) {
+	// Make a template that renders based on a key in Consul and restarts
+	// on change
+	key := "foo"
+	content := "bar"
+	embedded := fmt.Sprintf(`{{key "%s"}}`, key)
+	file := "my.tmpl"
+	template := &structs.Template{
+		EmbeddedTmpl: embedded,
+		DestPath:     file,
+		ChangeMode:   structs.TemplateChangeModeRestart,
+	}
+
+	// Drop the retry rate
+	testRetryRate = 10 * time.Millisecond
+
+	harness := newTestHarness(t, []*structs.Template{template}, false, true, false)
+	defer harness.stop()
+
+	// Write the key to Consul and wait for the initial unblocking render
+	harness.consul.SetKV(key, []byte(content))
+	time.Sleep(time.Duration(testutil.TestMultiplier()*200) * time.Millisecond)
+	if !harness.mockHooks.GetUnblocked() {
+		t.Fatalf("Task unblock should have been called")
+	}
+
+	// Write the exact same value repeatedly; consul-template re-renders
+	// each time, but since the bytes never change no restart should fire.
+	for i := 0; i < 3; i++ {
+		harness.consul.SetKV(key, []byte(content))
+		time.Sleep(time.Duration(testutil.TestMultiplier()*100) * time.Millisecond)
+	}
+
+	if harness.mockHooks.GetRestarts() != 0 {
+		t.Fatalf("Expected no restarts for no-op re-renders: %+v", harness.mockHooks)
+	}
+
+	// Check the file still has the expected content
+	path := filepath.Join(harness.taskDir, file)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read rendered template from %q: %v", path, err)
+	}
+	if s := string(raw); s != content {
+		t.Fatalf("Unexpected template data; got %q, want %q", s, content)
+	}
+}
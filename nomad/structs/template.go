@@ -0,0 +1,104 @@
+package structs
+
+import "time"
+
+const (
+	// TemplateChangeModeNoop marks that no action should be taken if the
+	// rendered template changes.
+	TemplateChangeModeNoop = "noop"
+
+	// TemplateChangeModeSignal marks that the task should be signaled if the
+	// rendered template changes.
+	TemplateChangeModeSignal = "signal"
+
+	// TemplateChangeModeRestart marks that the task should be restarted if the
+	// rendered template changes.
+	TemplateChangeModeRestart = "restart"
+
+	// TemplateChangeModeScript marks that the given ChangeScript should be
+	// executed inside the task if the rendered template changes.
+	TemplateChangeModeScript = "script"
+)
+
+// Template represents a template configuration to be rendered for a task
+// using consul-template.
+type Template struct {
+	// SourcePath is the path to the template to be rendered
+	SourcePath string
+
+	// DestPath is the path to render the template at, relative to the task
+	// directory.
+	DestPath string
+
+	// EmbeddedTmpl store the raw template. This is useful for smaller
+	// templates. This is mutually exclusive with SourcePath.
+	EmbeddedTmpl string
+
+	// ChangeMode indicates what should be done when the template is
+	// re-rendered. The options are "noop", "signal", "restart" and "script".
+	ChangeMode string
+
+	// ChangeSignal is the signal that should be sent if the change mode
+	// requires it.
+	ChangeSignal string
+
+	// ChangeScript is the command that should be executed in the task if
+	// ChangeMode is TemplateChangeModeScript.
+	ChangeScript *ChangeScript
+
+	// Splay is used to randomize the time at which the template's change
+	// signal is sent. The actual delay is chosen uniformly at random from
+	// the interval [0, Splay) so that a burst of re-renders across an
+	// allocation group doesn't signal every task at once. Splay applies to
+	// the "signal" change mode only; restarts and change scripts fire as
+	// soon as ChangeWait has elapsed.
+	Splay time.Duration
+
+	// ChangeWait is the amount of time with no further re-renders to wait
+	// before invoking ChangeMode; each re-render within it starts the wait
+	// over, coalescing rapid-fire changes (e.g. a Consul prefix rewrite or a
+	// Vault lease rotation) into a single signal/restart.
+	ChangeWait time.Duration
+
+	// Perms is the permission the file should be written out with.
+	Perms string
+
+	// LeftDelim and RightDelim are optional configurations to control what
+	// delimiter is utilized when parsing the template.
+	LeftDelim  string
+	RightDelim string
+
+	// Envvars enables exposing the template as environment variables
+	// instead of as a file. The template must be of the form 'key=value'.
+	Envvars bool
+}
+
+// ChangeScript holds the configuration for executing a script inside the
+// task when a template using TemplateChangeModeScript is re-rendered.
+type ChangeScript struct {
+	// Command is the command to execute, resolved relative to the task's
+	// working directory.
+	Command string
+
+	// Args are the arguments to pass to Command.
+	Args []string
+
+	// Timeout is the maximum amount of time to let the command run before
+	// it is killed.
+	Timeout time.Duration
+}
+
+// Copy returns a deep copy of the template.
+func (t *Template) Copy() *Template {
+	if t == nil {
+		return nil
+	}
+	copy := new(Template)
+	*copy = *t
+	if t.ChangeScript != nil {
+		script := *t.ChangeScript
+		script.Args = append([]string(nil), t.ChangeScript.Args...)
+		copy.ChangeScript = &script
+	}
+	return copy
+}